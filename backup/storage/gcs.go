@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsBackend writes to a Google Cloud Storage bucket under prefix, for
+// "gcs://bucket/prefix" URLs. Credentials are read from the standard
+// GOOGLE_APPLICATION_CREDENTIALS environment variable.
+type gcsBackend struct {
+	bucket *storage.BucketHandle
+	prefix string
+}
+
+// NewGCS builds a Backend for a "gcs://bucket/prefix" URL.
+func NewGCS(u *url.URL) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage: gcs URL must be gcs://bucket[/prefix], got %q", u.String())
+	}
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &gcsBackend{
+		bucket: client.Bucket(u.Host),
+		prefix: strings.TrimPrefix(u.Path, "/"),
+	}, nil
+}
+
+func (b *gcsBackend) key(path string) string {
+	if b.prefix == "" {
+		return path
+	}
+	return b.prefix + "/" + path
+}
+
+func (b *gcsBackend) Create(path string) (io.WriteCloser, error) {
+	return b.bucket.Object(b.key(path)).NewWriter(context.Background()), nil
+}
+
+// MkdirAll is a no-op: GCS has no directories, only object name prefixes.
+func (b *gcsBackend) MkdirAll(path string) error {
+	return nil
+}
+
+func (b *gcsBackend) Stat(path string) (Info, error) {
+	attrs, err := b.bucket.Object(b.key(path)).Attrs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return gcsInfo{size: attrs.Size}, nil
+}
+
+func (b *gcsBackend) Rename(oldPath, newPath string) error {
+	ctx := context.Background()
+	src := b.bucket.Object(b.key(oldPath))
+	dst := b.bucket.Object(b.key(newPath))
+	if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+		return err
+	}
+	return src.Delete(ctx)
+}
+
+func (b *gcsBackend) Exists(path string) bool {
+	_, err := b.Stat(path)
+	return err == nil
+}
+
+type gcsInfo struct{ size int64 }
+
+func (i gcsInfo) Size() int64 { return i.size }
+func (i gcsInfo) IsDir() bool { return false }
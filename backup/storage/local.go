@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"io"
+	"os"
+)
+
+// localBackend writes to the filesystem rooted at dir, preserving the
+// behavior the tool has always had.
+type localBackend struct {
+	dir string
+}
+
+// NewLocal returns a Backend rooted at dir (the existing "-o"/"--dir"
+// output directory).
+func NewLocal(dir string) Backend {
+	return &localBackend{dir: dir}
+}
+
+func (b *localBackend) resolve(path string) string {
+	if b.dir == "" || b.dir == "." {
+		return path
+	}
+	return b.dir + string(os.PathSeparator) + path
+}
+
+func (b *localBackend) Create(path string) (io.WriteCloser, error) {
+	return os.Create(b.resolve(path))
+}
+
+func (b *localBackend) MkdirAll(path string) error {
+	return os.MkdirAll(b.resolve(path), 0755)
+}
+
+func (b *localBackend) Stat(path string) (Info, error) {
+	return os.Stat(b.resolve(path))
+}
+
+func (b *localBackend) Rename(oldPath, newPath string) error {
+	return os.Rename(b.resolve(oldPath), b.resolve(newPath))
+}
+
+func (b *localBackend) Exists(path string) bool {
+	_, err := os.Stat(b.resolve(path))
+	return !os.IsNotExist(err)
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/spf13/viper"
+)
+
+// s3Backend writes to an S3 bucket under prefix, e.g. for
+// "s3://bucket/prefix" bucket="bucket" and prefix="prefix".
+type s3Backend struct {
+	bucket string
+	prefix string
+	svc    *s3.S3
+	up     *s3manager.Uploader
+}
+
+// NewS3 builds a Backend for a "s3://bucket/prefix" URL. Credentials come
+// from the standard AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY environment
+// variables (or the shared config/credentials files), falling back to the
+// "s3.region" Viper key for the region.
+func NewS3(u *url.URL) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage: s3 URL must be s3://bucket[/prefix], got %q", u.String())
+	}
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(viper.GetString("s3.region")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Backend{
+		bucket: u.Host,
+		prefix: strings.TrimPrefix(u.Path, "/"),
+		svc:    s3.New(sess),
+		up:     s3manager.NewUploader(sess),
+	}, nil
+}
+
+func (b *s3Backend) key(path string) string {
+	if b.prefix == "" {
+		return path
+	}
+	return b.prefix + "/" + path
+}
+
+func (b *s3Backend) Create(path string) (io.WriteCloser, error) {
+	return newS3Writer(b, b.key(path)), nil
+}
+
+// MkdirAll is a no-op: S3 has no directories, only key prefixes.
+func (b *s3Backend) MkdirAll(path string) error {
+	return nil
+}
+
+func (b *s3Backend) Stat(path string) (Info, error) {
+	out, err := b.svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(path)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s3Info{size: aws.Int64Value(out.ContentLength)}, nil
+}
+
+func (b *s3Backend) Rename(oldPath, newPath string) error {
+	oldKey, newKey := b.key(oldPath), b.key(newPath)
+	// CopySource is "bucket/key" but the key segment must be URL-encoded
+	// per the CopyObject API; course/lecture titles routinely contain
+	// spaces and other characters that otherwise break the copy
+	_, err := b.svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(b.bucket),
+		CopySource: aws.String(b.bucket + "/" + url.QueryEscape(oldKey)),
+		Key:        aws.String(newKey),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(oldKey),
+	})
+	return err
+}
+
+func (b *s3Backend) Exists(path string) bool {
+	_, err := b.Stat(path)
+	return err == nil
+}
+
+type s3Info struct{ size int64 }
+
+func (i s3Info) Size() int64 { return i.size }
+func (i s3Info) IsDir() bool { return false }
+
+// s3Writer buffers an upload to a temp file and flushes it to S3 on Close,
+// since s3manager.Uploader needs an io.Reader rather than a streaming
+// io.Writer.
+type s3Writer struct {
+	b   *s3Backend
+	key string
+	tmp *os.File
+	err error
+}
+
+func newS3Writer(b *s3Backend, key string) *s3Writer {
+	return &s3Writer{b: b, key: key}
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.tmp == nil {
+		f, err := os.CreateTemp("", "udemy-backup-s3-*")
+		if err != nil {
+			w.err = err
+			return 0, err
+		}
+		w.tmp = f
+	}
+	return w.tmp.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.tmp == nil {
+		return nil
+	}
+	defer os.Remove(w.tmp.Name())
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := w.b.up.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(w.b.bucket),
+		Key:    aws.String(w.key),
+		Body:   w.tmp,
+	})
+	if cerr := w.tmp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
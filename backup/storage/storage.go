@@ -0,0 +1,56 @@
+// Package storage abstracts the destination of a backup: a local directory
+// today, but also S3, GCS or WebDAV so a course can be streamed straight
+// into cloud storage instead of disk.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Info describes a path on a Backend, mirroring the subset of os.FileInfo
+// that callers in this repo actually rely on.
+type Info interface {
+	Size() int64
+	IsDir() bool
+}
+
+// Backend is anywhere a course backup can be written to. Paths passed to a
+// Backend are always slash-separated and relative to the backend's root
+// (the "prefix" of an s3:// or gcs:// URL, the output directory for local).
+type Backend interface {
+	// Create opens path for writing, creating or truncating it.
+	Create(path string) (io.WriteCloser, error)
+	// MkdirAll ensures path exists as a directory, creating parents as
+	// needed. Backends with no directory concept (S3, GCS) treat it as a
+	// no-op, since a key prefix needs no explicit creation.
+	MkdirAll(path string) error
+	// Stat returns metadata about path.
+	Stat(path string) (Info, error)
+	// Rename moves oldPath to newPath, e.g. to atomically publish a
+	// ".tmp" file once a download completes.
+	Rename(oldPath, newPath string) error
+	// Exists reports whether path is present on the backend.
+	Exists(path string) bool
+}
+
+// New parses raw (a plain local path, or a "scheme://..." remote URL) and
+// returns the matching Backend. Credentials for remote backends are read
+// from Viper configuration and the environment by each implementation.
+func New(raw string) (Backend, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return NewLocal(raw), nil
+	}
+	switch u.Scheme {
+	case "s3":
+		return NewS3(u)
+	case "gcs":
+		return NewGCS(u)
+	case "webdav":
+		return NewWebDAV(u)
+	default:
+		return nil, fmt.Errorf("storage: unsupported backend %q", u.Scheme)
+	}
+}
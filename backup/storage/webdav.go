@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"github.com/spf13/viper"
+	"github.com/studio-b12/gowebdav"
+)
+
+// webdavBackend writes to a WebDAV share, for "webdav://host/path" URLs.
+// Credentials come from the "webdav.username"/"webdav.password" Viper keys.
+type webdavBackend struct {
+	client *gowebdav.Client
+}
+
+// NewWebDAV builds a Backend for a "webdav://host/path" URL.
+func NewWebDAV(u *url.URL) (Backend, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("storage: webdav URL must be webdav://host/path, got %q", u.String())
+	}
+	root := &url.URL{Scheme: "https", Host: u.Host, Path: u.Path}
+	client := gowebdav.NewClient(root.String(), viper.GetString("webdav.username"), viper.GetString("webdav.password"))
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	return &webdavBackend{client: client}, nil
+}
+
+func (b *webdavBackend) Create(path string) (io.WriteCloser, error) {
+	return newWebdavWriter(b.client, path), nil
+}
+
+func (b *webdavBackend) MkdirAll(path string) error {
+	return b.client.MkdirAll(path, 0755)
+}
+
+func (b *webdavBackend) Stat(path string) (Info, error) {
+	return b.client.Stat(path)
+}
+
+func (b *webdavBackend) Rename(oldPath, newPath string) error {
+	return b.client.Rename(oldPath, newPath, true)
+}
+
+func (b *webdavBackend) Exists(path string) bool {
+	_, err := b.client.Stat(path)
+	return err == nil
+}
+
+// webdavWriter buffers an upload to a temp file and streams it to the
+// WebDAV share on Close, mirroring s3Writer: a multi-GB chunked download
+// (see concatenateChunks in main.go) shouldn't have to fit in memory
+// first.
+type webdavWriter struct {
+	client *gowebdav.Client
+	path   string
+	tmp    *os.File
+	err    error
+}
+
+func newWebdavWriter(c *gowebdav.Client, path string) *webdavWriter {
+	return &webdavWriter{client: c, path: path}
+}
+
+func (w *webdavWriter) Write(p []byte) (int, error) {
+	if w.tmp == nil {
+		f, err := os.CreateTemp("", "udemy-backup-webdav-*")
+		if err != nil {
+			w.err = err
+			return 0, err
+		}
+		w.tmp = f
+	}
+	return w.tmp.Write(p)
+}
+
+func (w *webdavWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.tmp == nil {
+		return nil
+	}
+	defer os.Remove(w.tmp.Name())
+	if _, err := w.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err := w.client.WriteStream(w.path, w.tmp, os.FileMode(0644))
+	if cerr := w.tmp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
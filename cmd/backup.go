@@ -3,14 +3,17 @@ package cmd
 import (
 	"context"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/ushu/udemy-backup/backup"
 	"github.com/ushu/udemy-backup/cli"
 	"github.com/ushu/udemy-backup/client"
+	"github.com/ushu/udemy-backup/logging"
 )
 
 var PreferredResolution int
@@ -19,6 +22,12 @@ var Dir string
 var Restart bool
 var All bool
 var Subtitles bool
+var Remote string
+var RateLimit float64
+var RateLimitUnit string
+var ResetState bool
+var LogLevel string
+var LogFormat string
 
 // backupCmd represents the backup command
 var backupCmd = &cobra.Command{
@@ -36,21 +45,46 @@ func init() {
 	backupCmd.PersistentFlags().BoolVar(&Restart, "restart", false, "skip download of existing files")
 	backupCmd.PersistentFlags().BoolVar(&All, "all", false, "backup all the subscribed courses for the account")
 	backupCmd.PersistentFlags().BoolVar(&Subtitles, "subtitles", false, "download subtitles (vtt) files")
+	backupCmd.PersistentFlags().StringVar(&Remote, "storage", "", `storage backend URL, e.g. "s3://bucket/prefix"; only bound to the "storage" config key, which the backup command does not read`)
+	backupCmd.PersistentFlags().Float64Var(&RateLimit, "ratelimit", 0, `max aggregate download bandwidth, in -ratelimit-unit/s; only bound to the "ratelimit" config key, which the backup command does not read`)
+	backupCmd.PersistentFlags().StringVar(&RateLimitUnit, "ratelimit-unit", "MB", `unit for -ratelimit: "B", "KB", "MB" or "GB"; only bound to the "ratelimit-unit" config key, which the backup command does not read`)
+	backupCmd.PersistentFlags().BoolVar(&ResetState, "reset-state", false, `wipe the per-course resume state file; only bound to the "reset-state" config key, which the backup command does not read`)
+	backupCmd.PersistentFlags().StringVar(&LogLevel, "log-level", "info", `log level: "debug", "info", "warn" or "error"`)
+	backupCmd.PersistentFlags().StringVar(&LogFormat, "log-format", "text", `log output format: "text" or "json"`)
 	viper.BindPFlag("resolution", backupCmd.PersistentFlags().Lookup("resolution"))
 	viper.BindPFlag("concurrency", backupCmd.PersistentFlags().Lookup("concurrency"))
 	viper.BindPFlag("dir", backupCmd.PersistentFlags().Lookup("dir"))
 	viper.BindPFlag("restart", backupCmd.PersistentFlags().Lookup("restart"))
 	viper.BindPFlag("subtitles", backupCmd.PersistentFlags().Lookup("subtitles"))
+	viper.BindPFlag("storage", backupCmd.PersistentFlags().Lookup("storage"))
+	viper.BindPFlag("ratelimit", backupCmd.PersistentFlags().Lookup("ratelimit"))
+	viper.BindPFlag("ratelimit-unit", backupCmd.PersistentFlags().Lookup("ratelimit-unit"))
+	viper.BindPFlag("reset-state", backupCmd.PersistentFlags().Lookup("reset-state"))
+	viper.BindPFlag("log-level", backupCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("log-format", backupCmd.PersistentFlags().Lookup("log-format"))
 }
 
 func runBackup(cmd *cobra.Command, args []string) {
-	ctx := context.Background()
+	logger := logging.New(os.Stderr, LogLevel, LogFormat)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancel ctx on SIGINT/SIGTERM so in-flight downloads stop cleanly
+	// instead of leaving the terminal or partial files in a weird state
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		logger.Warn().Msg("interrupted, shutting down...")
+		cancel()
+	}()
+	defer signal.Stop(sigc)
 
 	// grab credentials
 	id, token, err := cli.EnsureCredentials()
 	if err != nil {
-		cli.Logerr("Failed to load credentials: %v\n", err)
-		os.Exit(1)
+		logger.Fatal().Err(err).Msg("failed to load credentials")
 	}
 
 	// we can now connect to Udemy
@@ -70,15 +104,14 @@ func runBackup(cmd *cobra.Command, args []string) {
 			// list all the course
 			courses, err := c.ListAllCourses()
 			if err != nil {
-				cli.Logerrf("Failed to list courses: %v\n", err)
-				os.Exit(1)
+				logger.Fatal().Err(err).Msg("failed to list courses")
 			}
-			cli.Logf("⚙️  Found %d courses to backup\n", len(courses))
+			logger.Info().Int("count", len(courses)).Msg("found courses to backup")
 
 			for _, course := range courses {
-				cli.Log("⚙️  Starting backup for:", course.Title)
+				logger.Info().Int("course_id", course.ID).Msg(course.Title)
 				if err = backup.BackupCourse(ctx, cfg, course); err != nil {
-					os.Exit(1)
+					logger.Fatal().Err(err).Int("course_id", course.ID).Msg("backup failed")
 				}
 			}
 		} else {
@@ -86,38 +119,34 @@ func runBackup(cmd *cobra.Command, args []string) {
 			if len(args) > 0 {
 				courseID, err := strconv.Atoi(args[0])
 				if err != nil {
-					cli.Logerr("COURSE_ID should be a number (integer)")
+					logger.Fatal().Err(err).Str("arg", args[0]).Msg("COURSE_ID should be a number (integer)")
 				}
 				course, err = c.GetCourse(courseID)
 				if err != nil {
-					cli.Logerr("Could not load course info:", err)
-					os.Exit(1)
+					logger.Fatal().Err(err).Int("course_id", courseID).Msg("could not load course info")
 				}
 			} else {
 				// list all the course
 				courses, err := c.ListAllCourses()
 				if err != nil {
-					cli.Logerrf("Failed to list courses: %v\n", err)
-					os.Exit(1)
+					logger.Fatal().Err(err).Msg("failed to list courses")
 				}
 
 				// prompt the user to select a course
 				course, err = cli.SelectCourse(courses)
 				if err != nil {
-					cli.Logerrf("Could not select course: %v\n", err)
-					os.Exit(1)
+					logger.Fatal().Err(err).Msg("could not select course")
 				}
 			}
 
 			// backup starts here
 			if err = backup.BackupCourse(ctx, cfg, course); err != nil {
-				os.Exit(1)
+				logger.Fatal().Err(err).Int("course_id", course.ID).Msg("backup failed")
 			}
 		}
 	}()
 
 	if err := workerPool.Start(ctx); err != nil {
-		cli.Logerr("Backup failed:", err)
-		os.Exit(1)
+		logger.Fatal().Err(err).Msg("backup failed")
 	}
 }
@@ -0,0 +1,85 @@
+// Package logging provides the leveled, structured logger used by the CLI,
+// and a writer that keeps its output from interleaving with an active
+// progress bar.
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// Logger is the leveled logger used across the CLI, exposing
+// Debug/Info/Warn/Error (plus Fatal) with structured fields such as
+// course_id, asset_id, url, bytes and attempt.
+type Logger = zerolog.Logger
+
+// New builds a Logger writing to w at level ("debug", "info", "warn" or
+// "error", defaulting to "info"), formatted as "text" (human-readable,
+// the default) or "json".
+func New(w io.Writer, level, format string) Logger {
+	out := w
+	if format != "json" {
+		out = zerolog.ConsoleWriter{Out: w, TimeFormat: "15:04:05"}
+	}
+	l := zerolog.New(out).With().Timestamp().Logger()
+	switch strings.ToLower(level) {
+	case "debug":
+		return l.Level(zerolog.DebugLevel)
+	case "warn":
+		return l.Level(zerolog.WarnLevel)
+	case "error":
+		return l.Level(zerolog.ErrorLevel)
+	default:
+		return l.Level(zerolog.InfoLevel)
+	}
+}
+
+// BarWriter is the io.Writer a pb.Pool renders its (multi-line) bar block
+// through. It remembers how many lines that block spans, so the writer
+// returned by LogWriter can erase exactly that block before a log line
+// prints; the pool's own render loop then simply repaints over it on its
+// next tick. Both writers share a mutex, so a bar redraw and a log line
+// can never land interleaved on out.
+type BarWriter struct {
+	mu    sync.Mutex
+	out   io.Writer
+	lines int
+}
+
+// NewBarWriter returns a BarWriter writing to out. Pass it as a pb.Pool's
+// Output, and pass its LogWriter() to the logger.
+func NewBarWriter(out io.Writer) *BarWriter {
+	return &BarWriter{out: out}
+}
+
+// Write implements io.Writer for the pb.Pool: it is called with the full
+// redrawn bar block on every tick.
+func (w *BarWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lines = bytes.Count(p, []byte{'\n'})
+	return w.out.Write(p)
+}
+
+// LogWriter returns the io.Writer the logger should write to: each Write
+// erases the bar's last-known block, via cursor-up-N-lines plus
+// clear-to-end escape codes, before printing the log line underneath it.
+func (w *BarWriter) LogWriter() io.Writer {
+	return logWriter{w}
+}
+
+type logWriter struct{ bw *BarWriter }
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.bw.mu.Lock()
+	defer w.bw.mu.Unlock()
+	if w.bw.lines > 0 {
+		fmt.Fprintf(w.bw.out, "\x1b[%dA\r\x1b[J", w.bw.lines)
+	}
+	return w.bw.out.Write(p)
+}
@@ -2,22 +2,47 @@ package main
 
 import (
 	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"sync"
+	"syscall"
 
 	"github.com/ushu/udemy-backup/backup"
+	"github.com/ushu/udemy-backup/backup/storage"
 	"github.com/ushu/udemy-backup/client"
 	"github.com/ushu/udemy-backup/client/lister"
+	"github.com/ushu/udemy-backup/logging"
+	"golang.org/x/time/rate"
 	pb "gopkg.in/cheggaaa/pb.v1"
 )
 
+// stateFileName is the per-course file recording assets that have already
+// been written, so a run can resume cleanly even if local filenames change.
+const stateFileName = ".udemy-backup.state.json"
+
+// exitInterrupted is returned to the shell on SIGINT/SIGTERM, distinct from
+// the generic fatal-error exit code, so callers can tell a clean
+// interruption from a real failure.
+const exitInterrupted = 130
+
+// Below this size a file is always fetched with a single GET: the overhead of
+// a HEAD probe plus N range requests isn't worth it for small assets.
+const chunkedDownloadThreshold = 16 * 1024 * 1024
+
+// Number of chunks a large, range-capable download is split into.
+const downloadChunks = 4
+
 // Version of the tool
 var Version = "0.4.1"
 
@@ -31,34 +56,53 @@ OPTIONS:
 
 // Flag values
 var (
-	showHelp    bool
-	showVersion bool
-	downloadAll bool
-	quiet       bool
-	redownload  bool
-	output      string
-	clientID    string
-	accessToken string
+	showHelp      bool
+	showVersion   bool
+	downloadAll   bool
+	quiet         bool
+	redownload    bool
+	output        string
+	remote        string
+	clientID      string
+	accessToken   string
+	ratelimit     float64
+	ratelimitUnit string
+	resetState    bool
+	logLevel      string
+	logFormat     string
 )
 
 // Number of parallel workers
 var concurrency int
 
+// logger is the structured logger used across the CLI, built in main()
+// once the "-log-level"/"-log-format" flags are parsed.
+var logger logging.Logger
+
+// barWriter is the pb.Pool's Output in downloadCourse; the logger writes
+// through its LogWriter() so the two share a mutex and a log line always
+// erases the bar block first instead of the two interleaving on stderr.
+var barWriter *logging.BarWriter
+
 func init() {
 	flag.BoolVar(&downloadAll, "a", false, "download all the courses enrolled by the user")
 	flag.BoolVar(&showHelp, "h", false, "show usage info")
 	flag.StringVar(&output, "o", ".", "output directory")
+	flag.StringVar(&remote, "remote", "", `storage backend URL to write the backup to, e.g. "s3://bucket/prefix" (defaults to the local "-o" directory)`)
 	flag.BoolVar(&quiet, "q", false, "disable output messages")
 	flag.BoolVar(&redownload, "r", false, "force re-download of existing files")
 	flag.BoolVar(&showVersion, "v", false, "show version number")
 	flag.StringVar(&clientID, "c", "", "the client ID")
 	flag.StringVar(&accessToken, "t", "", "the Access Token")
+	flag.Float64Var(&ratelimit, "ratelimit", 0, "max aggregate download bandwidth, in -ratelimit-unit/s (0 = unlimited)")
+	flag.StringVar(&ratelimitUnit, "ratelimit-unit", "MB", `unit for -ratelimit: "B", "KB", "MB" or "GB"`)
+	flag.BoolVar(&resetState, "reset-state", false, "wipe the per-course resume state file before starting")
+	flag.StringVar(&logLevel, "log-level", "info", `log level: "debug", "info", "warn" or "error"`)
+	flag.StringVar(&logFormat, "log-format", "text", `log output format: "text" or "json"`)
 	flag.Usage = func() {
 		fmt.Print(usageDescription)
 		flag.PrintDefaults()
 	}
-	log.SetFlags(0)
-	log.SetPrefix("")
 	concurrency = runtime.GOMAXPROCS(0)
 	if concurrency > 8 {
 		concurrency = 8
@@ -67,9 +111,7 @@ func init() {
 
 func main() {
 	flag.Parse()
-	ctx := context.Background()
 
-	// Parse flags
 	if showHelp {
 		flag.Usage()
 		return
@@ -78,9 +120,31 @@ func main() {
 		fmt.Printf("v%s\n", Version)
 		return
 	}
+
+	// the progress pool (see downloadCourse) renders through barWriter, and
+	// the logger writes through its LogWriter(), so the two can never
+	// interleave on stderr
+	barWriter = logging.NewBarWriter(os.Stderr)
+	var logOut io.Writer = barWriter.LogWriter()
 	if quiet {
-		log.SetOutput(ioutil.Discard)
+		logOut = ioutil.Discard
 	}
+	logger = logging.New(logOut, logLevel, logFormat)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancel the root context on SIGINT/SIGTERM so in-flight downloads stop
+	// cleanly instead of leaving the terminal or partial files in a weird
+	// state
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		logger.Warn().Msg("interrupted, shutting down...")
+		cancel()
+	}()
+	defer signal.Stop(sigc)
 
 	// Connect to the Udemy backend
 	c := client.New()
@@ -88,11 +152,11 @@ func main() {
 		// log the user in
 		e, p, err := askCredentials()
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal().Err(err).Msg("failed to read credentials")
 		}
 		_, err = c.Login(ctx, e, p)
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal().Err(err).Msg("login failed")
 		}
 	} else {
 		c.Credentials.ID = clientID
@@ -103,29 +167,56 @@ func main() {
 	l := lister.New(c)
 	courses, err := l.ListAllCourses(ctx)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("failed to list courses")
+	}
+
+	// the backend the backup is written to: "-remote" for S3/GCS/WebDAV,
+	// or the local "-o" directory when unset
+	backend, err := newBackend()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize storage backend")
 	}
 
 	// we're logged in !
 	if downloadAll {
 		for _, course := range courses {
-			log.Printf("🚀 %s", course.Title)
-			if err = downloadCourse(ctx, c, course); err != nil {
-				log.Fatal(err)
+			logger.Info().Int("course_id", course.ID).Msg(course.Title)
+			if err = downloadCourse(ctx, c, course, backend); err != nil {
+				exitOnDownloadError(ctx, err)
 			}
 		}
 	} else {
 		course, err := selectCourse(courses)
 		if err != nil {
-			log.Fatal(err)
+			logger.Fatal().Err(err).Msg("failed to select course")
 		}
-		if err = downloadCourse(ctx, c, course); err != nil {
-			log.Fatal(err)
+		if err = downloadCourse(ctx, c, course, backend); err != nil {
+			exitOnDownloadError(ctx, err)
 		}
 	}
 }
 
-func downloadCourse(ctx context.Context, client *client.Client, course *client.Course) error {
+// exitOnDownloadError terminates the process for a failed downloadCourse
+// call, using exitInterrupted when the failure is the root context having
+// been canceled (SIGINT/SIGTERM) rather than a real download error.
+func exitOnDownloadError(ctx context.Context, err error) {
+	if ctx.Err() == context.Canceled {
+		os.Exit(exitInterrupted)
+	}
+	logger.Fatal().Err(err).Msg("download failed")
+}
+
+// newBackend returns the storage.Backend the backup is written to: the
+// "-remote" URL when set, otherwise the local "-o" directory (paths handed
+// to the backend already include that directory, so it is rooted at "").
+func newBackend() (storage.Backend, error) {
+	if remote != "" {
+		return storage.New(remote)
+	}
+	return storage.NewLocal(""), nil
+}
+
+func downloadCourse(ctx context.Context, client *client.Client, course *client.Course, backend storage.Backend) error {
 	var err error
 
 	// list all the available course elements
@@ -137,18 +228,30 @@ func downloadCourse(ctx context.Context, client *client.Client, course *client.C
 
 	// create all the required directories
 	for _, d := range dirs {
-		if !dirExists(d) {
-			if err = os.MkdirAll(d, 0755); err != nil {
-				log.Fatal(err)
+		if !backend.Exists(d) {
+			if err = backend.MkdirAll(d); err != nil {
+				logger.Fatal().Err(err).Int("course_id", course.ID).Str("dir", d).Msg("failed to create directory")
 			}
 		}
 	}
 
+	// per-course resume state: records which assets were already written,
+	// so a run survives partial completions even once local filenames
+	// change (e.g. after a course is re-ordered upstream)
+	statePath := courseStatePath(course)
+	if resetState {
+		_ = os.Remove(statePath)
+	}
+	state, err := loadDownloadState(statePath)
+	if err != nil {
+		return err
+	}
+
 	// filter already-downloaded assets when "redownload" is selected
 	var assets []backup.Asset
 	if !redownload {
 		for _, a := range allAssets {
-			if !fileExists(a.LocalPath) {
+			if !backend.Exists(a.LocalPath) && !state.Done(a.ID) {
 				assets = append(assets, a)
 			}
 		}
@@ -156,15 +259,34 @@ func downloadCourse(ctx context.Context, client *client.Client, course *client.C
 		assets = allAssets
 	}
 
-	// create the "bar"
-	var bar *pb.ProgressBar
+	// create the progress pool: one bar per worker, showing the asset it is
+	// currently fetching and its throughput, plus a "total" bar tracking how
+	// many of the overall assets are done
+	workerBars := make([]*pb.ProgressBar, concurrency)
+	var totalBar *pb.ProgressBar
 	if !quiet {
-		bar = pb.New(len(allAssets))
-		bar.Add(len(allAssets) - len(assets))
-		bar.Start()
-		defer bar.Update()
+		for i := range workerBars {
+			workerBars[i] = pb.New64(0).SetUnits(pb.U_BYTES)
+			workerBars[i].ShowSpeed = true
+		}
+		totalBar = pb.New(len(allAssets))
+		totalBar.Prefix("Total")
+		totalBar.Add(len(allAssets) - len(assets))
+
+		// render through barWriter (instead of the pool's stdout default)
+		// so the logger's LogWriter can erase the bar block before a log
+		// line, and the next redraw simply repaints over it
+		pool := pb.NewPool(append(append([]*pb.ProgressBar{}, workerBars...), totalBar)...)
+		pool.Output = barWriter
+		if err := pool.Start(); err != nil {
+			logger.Fatal().Err(err).Msg("failed to start progress pool")
+		}
+		defer pool.Stop()
 	}
 
+	// a single limiter shared by all workers caps aggregate bandwidth
+	limiter := newRateLimiter()
+
 	// start a cancelable context
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -177,36 +299,76 @@ func downloadCourse(ctx context.Context, client *client.Client, course *client.C
 	var wg sync.WaitGroup
 	wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
-		go func() {
+		go func(bar *pb.ProgressBar) {
 			defer wg.Done()
 			for a := range chwork {
+				if !quiet {
+					bar.Prefix(a.Title)
+				}
 				if a.RemoteURL != "" {
 					var err error
 				Retries:
 					for retry := 0; retry < 3; retry++ {
-						err = downloadURLToFile(ctx, client.HTTPClient, a.RemoteURL, a.LocalPath)
+						// reset per-attempt, not just once per asset: a
+						// retried asset would otherwise keep accumulating
+						// progress from its failed attempt(s) on top of
+						// the new one
+						if !quiet {
+							bar.Set64(0)
+							bar.SetTotal64(0)
+						}
+						err = downloadURLToFile(ctx, client.HTTPClient, backend, a.RemoteURL, a.LocalPath, bar, limiter)
 						if err == nil {
 							break Retries
 						}
+						logger.Warn().
+							Err(err).
+							Int("course_id", course.ID).
+							Str("asset_id", a.ID).
+							Str("url", a.RemoteURL).
+							Int("attempt", retry+1).
+							Msg("download attempt failed")
+					}
+					if err == nil {
+						err = state.Record(a.ID, a.LocalPath)
+					} else {
+						logger.Error().
+							Err(err).
+							Int("course_id", course.ID).
+							Str("asset_id", a.ID).
+							Str("url", a.RemoteURL).
+							Msg("download failed after all retries")
 					}
 					cherr <- err
 				} else if len(a.Contents) > 0 {
-					cherr <- ioutil.WriteFile(a.LocalPath, a.Contents, os.ModePerm)
+					err := writeFileToBackend(backend, a.LocalPath, a.Contents)
+					if err == nil {
+						err = state.Record(a.ID, a.LocalPath)
+					} else {
+						logger.Error().
+							Err(err).
+							Int("course_id", course.ID).
+							Str("asset_id", a.ID).
+							Int("bytes", len(a.Contents)).
+							Msg("failed to write asset")
+					}
+					cherr <- err
 				}
 				if !quiet {
-					bar.Increment()
+					totalBar.Increment()
 				}
 			}
-		}()
+		}(workerBars[i])
 	}
 
 	// and the "pusher" goroutine
 	go func() {
 		// enqueue all assets (unless we cancel)
+	Enqueue:
 		for _, a := range assets {
 			select {
 			case <-ctx.Done():
-				break
+				break Enqueue
 			case chwork <- a:
 			}
 		}
@@ -225,11 +387,274 @@ func downloadCourse(ctx context.Context, client *client.Client, course *client.C
 	return nil
 }
 
-func downloadURLToFile(ctx context.Context, c *http.Client, url, filePath string) error {
+func downloadURLToFile(ctx context.Context, c *http.Client, backend storage.Backend, url, filePath string, bar *pb.ProgressBar, limiter *rate.Limiter) error {
+	// large, range-capable assets are split into chunks and fetched in
+	// parallel; everything else falls back to the plain single-stream GET
+	if size, acceptsRanges, err := probeRangeSupport(ctx, c, url); err == nil &&
+		acceptsRanges && size >= chunkedDownloadThreshold {
+		if err := downloadURLToFileChunked(ctx, c, backend, url, filePath, size, bar, limiter); err == nil {
+			return nil
+		}
+		// a chunked download can fail halfway (server dropped Range support
+		// mid-run, disk error...); retry with the simple path rather than
+		// giving up on the asset entirely
+	}
+	return downloadURLToFileSingle(ctx, c, backend, url, filePath, bar, limiter)
+}
+
+// rateLimiterBurst is a fixed token-bucket burst size, deliberately kept
+// independent of "-ratelimit-unit": rate.Limiter.WaitN fails outright (it
+// doesn't throttle) whenever it's asked to wait for more than the burst,
+// and io.Copy reads up to 32KiB at a time, so a burst derived from
+// "-ratelimit-unit=B" or "=KB" is smaller than a single read and breaks
+// every download.
+const rateLimiterBurst = 1 << 20
+
+// newRateLimiter builds the shared bandwidth limiter for a backup run, or
+// nil when "-ratelimit"/"ratelimit" is unset (0), in which case callers skip
+// the wrapper entirely for zero overhead.
+func newRateLimiter() *rate.Limiter {
+	if ratelimit <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(ratelimit*float64(rateUnitBytes(ratelimitUnit))), rateLimiterBurst)
+}
+
+// rateUnitBytes converts a "-ratelimit-unit" value into a byte count.
+func rateUnitBytes(unit string) int64 {
+	switch unit {
+	case "KB":
+		return 1 << 10
+	case "MB":
+		return 1 << 20
+	case "GB":
+		return 1 << 30
+	default:
+		return 1
+	}
+}
+
+// limitReader wraps r so that every Read waits on limiter for the bytes it
+// returns, throttling aggregate throughput while still respecting ctx
+// cancellation. A nil limiter is a no-op.
+func limitReader(ctx context.Context, r io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// probeRangeSupport issues a HEAD request to learn the size of the remote
+// file and whether the server honors Range requests for it.
+func probeRangeSupport(ctx context.Context, c *http.Client, url string) (int64, bool, error) {
+	req, err := http.NewRequest("HEAD", url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	req = req.WithContext(ctx)
+	res, err := c.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	_ = res.Body.Close()
+	if res.StatusCode >= 400 {
+		return 0, false, fmt.Errorf("HEAD %s: %s", url, res.Status)
+	}
+	return res.ContentLength, res.Header.Get("Accept-Ranges") == "bytes", nil
+}
+
+// chunkRetries is how many attempts each individual chunk gets before the
+// whole chunked download gives up. downloadChunkToFile resumes from
+// whatever is already on disk, so a retry only re-fetches the missing
+// tail of that one chunk rather than the full asset.
+const chunkRetries = 3
+
+// downloadURLToFileChunked splits a download of the given size into
+// downloadChunks equal ranges, fetches each in parallel into its own local
+// "<filePath>.partN" scratch file (resuming any range already on disk),
+// then streams the concatenated parts through backend into filePath.
+func downloadURLToFileChunked(ctx context.Context, c *http.Client, backend storage.Backend, url, filePath string, size int64, bar *pb.ProgressBar, limiter *rate.Limiter) error {
+	if bar != nil {
+		bar.SetTotal64(size)
+	}
+
+	// chunk scratch files always live on local disk (see chunkScratchDir),
+	// so this must succeed regardless of which storage.Backend filePath
+	// ultimately belongs to
+	if err := os.MkdirAll(chunkScratchDir(filePath), 0755); err != nil {
+		return err
+	}
+
+	chunkSize := size / downloadChunks
+	if chunkSize == 0 {
+		chunkSize = size
+	}
+
+	var wg sync.WaitGroup
+	cherr := make(chan error, downloadChunks)
+	for i := 0; i < downloadChunks; i++ {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if i == downloadChunks-1 {
+			end = size - 1
+		}
+		// credit any bytes already on the chunk's scratch file to bar
+		// exactly once, before its retry loop starts: this only happens
+		// to be non-zero when resuming a chunk left over from an earlier
+		// (interrupted) process run. A retry *within* the loop below must
+		// not repeat this credit, since bytes written by a failed attempt
+		// were already reported live through copyWithBar as they were
+		// read.
+		if bar != nil {
+			if fi, err := os.Stat(chunkPartPath(filePath, i)); err == nil {
+				bar.Add64(fi.Size())
+			}
+		}
+		wg.Add(1)
+		go func(idx int, start, end int64) {
+			defer wg.Done()
+			var err error
+			for attempt := 0; attempt < chunkRetries; attempt++ {
+				if err = downloadChunkToFile(ctx, c, url, filePath, idx, start, end, bar, limiter); err == nil {
+					break
+				}
+			}
+			cherr <- err
+		}(i, start, end)
+	}
+	wg.Wait()
+	close(cherr)
+	for err := range cherr {
+		if err != nil {
+			// a chunk exhausted its retries: don't leave partial scratch
+			// data behind for the single-stream fallback to ignore
+			removeChunkParts(filePath)
+			return err
+		}
+	}
+
+	return concatenateChunks(backend, filePath, downloadChunks)
+}
+
+// removeChunkParts deletes the local scratch directory holding filePath's
+// ".partN" files, whether the chunked download succeeded
+// (concatenateChunks) or gave up (downloadURLToFileChunked, before
+// falling back to the single-stream path).
+func removeChunkParts(filePath string) {
+	_ = os.RemoveAll(chunkScratchDir(filePath))
+}
+
+// chunkScratchDir returns a local, always-creatable directory for the
+// ".partN" scratch files of filePath's chunked download. It is keyed off
+// filePath rather than derived from the storage.Backend's own layout: S3
+// and GCS backends treat MkdirAll as a no-op (they have no directories,
+// only key prefixes — see backup/storage/s3.go, backup/storage/gcs.go),
+// so downloadCourse's upfront directory creation never runs for a remote
+// backend, and chunk scratch writes can't depend on it.
+func chunkScratchDir(filePath string) string {
+	sum := sha1.Sum([]byte(filePath))
+	return filepath.Join(os.TempDir(), "udemy-backup-chunks", hex.EncodeToString(sum[:]))
+}
+
+// downloadChunkToFile fetches bytes [start, end] of url into the chunk's
+// ".partN" file. If the part file already holds some (or all) of that
+// range (whether from a resumed prior run or a previous, failed call to
+// this same function), only the missing tail is requested; bar is not
+// credited here for bytes the part file already holds — the caller
+// credits genuinely pre-existing bytes once, before any retry, since
+// copyWithBar already reports bytes as they're freshly read.
+func downloadChunkToFile(ctx context.Context, c *http.Client, url, filePath string, idx int, start, end int64, bar *pb.ProgressBar, limiter *rate.Limiter) error {
+	pPath := chunkPartPath(filePath, idx)
+	if fi, err := os.Stat(pPath); err == nil {
+		start += fi.Size()
+	}
+	if start > end {
+		return nil // chunk fully downloaded already
+	}
+
+	f, err := os.OpenFile(pPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("range request for %s (chunk %d) returned %s", url, idx, res.Status)
+	}
+
+	_, err = copyWithBar(f, limitReader(ctx, res.Body, limiter), bar)
+	return err
+}
+
+// concatenateChunks streams the downloadChunks local ".partN" files into
+// filePath on backend, in order, then removes the scratch files.
+func concatenateChunks(backend storage.Backend, filePath string, n int) error {
+	tmpPath := filePath + ".tmp"
+	out, err := backend.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := appendChunk(out, chunkPartPath(filePath, i)); err != nil {
+			_ = out.Close()
+			return err
+		}
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	removeChunkParts(filePath)
+	return backend.Rename(tmpPath, filePath)
+}
+
+func appendChunk(out io.Writer, partPath string) error {
+	in, err := os.Open(partPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+func chunkPartPath(filePath string, idx int) string {
+	return filepath.Join(chunkScratchDir(filePath), fmt.Sprintf("part%d", idx))
+}
+
+// downloadURLToFileSingle is the original, non-chunked download path: used
+// for small assets and as a fallback when the server doesn't support Range.
+func downloadURLToFileSingle(ctx context.Context, c *http.Client, backend storage.Backend, url, filePath string, bar *pb.ProgressBar, limiter *rate.Limiter) error {
 	tmpPath := filePath + ".tmp"
 
 	// open file for writing
-	f, err := os.Create(tmpPath)
+	f, err := backend.Create(tmpPath)
 	if err != nil {
 		return err
 	}
@@ -246,9 +671,12 @@ func downloadURLToFile(ctx context.Context, c *http.Client, url, filePath string
 		_ = f.Close()
 		return err
 	}
+	if bar != nil && res.ContentLength > 0 {
+		bar.SetTotal64(res.ContentLength)
+	}
 
 	// load all the data into the local file
-	_, err = io.Copy(f, res.Body)
+	_, err = copyWithBar(f, limitReader(ctx, res.Body, limiter), bar)
 	_ = res.Body.Close()
 	if err != nil {
 		_ = f.Close()
@@ -260,15 +688,106 @@ func downloadURLToFile(ctx context.Context, c *http.Client, url, filePath string
 	if err != nil {
 		return err
 	}
-	return os.Rename(tmpPath, filePath)
+	return backend.Rename(tmpPath, filePath)
 }
 
-func fileExists(name string) bool {
-	_, err := os.Stat(name)
-	return !os.IsNotExist(err)
+// copyWithBar copies src into dst, reporting byte progress on bar if set.
+func copyWithBar(dst io.Writer, src io.Reader, bar *pb.ProgressBar) (int64, error) {
+	if bar == nil {
+		return io.Copy(dst, src)
+	}
+	return io.Copy(dst, bar.NewProxyReader(src))
 }
 
-func dirExists(name string) bool {
-	s, err := os.Stat(name)
-	return !os.IsNotExist(err) && s.IsDir()
+// writeFileToBackend writes contents to path on backend, e.g. for
+// already-fetched asset bodies that don't need a streaming download.
+func writeFileToBackend(backend storage.Backend, path string, contents []byte) error {
+	f, err := backend.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(contents)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// courseStatePath returns the path to the resume state file for course,
+// rooted at the same output directory as its downloaded assets.
+func courseStatePath(course *client.Course) string {
+	return filepath.Join(output, strconv.Itoa(course.ID), stateFileName)
+}
+
+// stateEntry is one element of the resume state file's JSON array: an asset that has
+// already been written out, keyed by its (stable) asset ID rather than its
+// local path so renames upstream don't cause a re-download.
+type stateEntry struct {
+	AssetID   string `json:"asset_id"`
+	LocalPath string `json:"local_path"`
+}
+
+// downloadState tracks, and persists to disk, which assets of a course
+// have already been downloaded in a prior (possibly interrupted) run.
+type downloadState struct {
+	path string
+
+	mu   sync.Mutex
+	done map[string]string // asset ID -> local path
+}
+
+// loadDownloadState reads the state file at path, if any. A missing file
+// is not an error: it just means a fresh downloadState with nothing done.
+func loadDownloadState(path string) (*downloadState, error) {
+	s := &downloadState{path: path, done: make(map[string]string)}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	var entries []stateEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		s.done[e.AssetID] = e.LocalPath
+	}
+	return s, nil
+}
+
+// Done reports whether assetID was already recorded as downloaded.
+func (s *downloadState) Done(assetID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.done[assetID]
+	return ok
+}
+
+// Record marks assetID as downloaded to localPath and flushes the state
+// file to disk (via a ".tmp" + rename, so a crash mid-write can't corrupt
+// it).
+func (s *downloadState) Record(assetID, localPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done[assetID] = localPath
+
+	entries := make([]stateEntry, 0, len(s.done))
+	for id, p := range s.done {
+		entries = append(entries, stateEntry{AssetID: id, LocalPath: p})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
 }